@@ -0,0 +1,31 @@
+package miner
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/go-filecoin/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDeadlineCloseAtHeight guards against deadlineCloseAtHeight regressing
+// to a fixed offset from ProvingPeriodStart, which only matches a
+// deadline's real close height during the miner's first proving period and
+// otherwise lets every dispute revert with ErrDisputeWindowExpired
+// immediately, no matter how fresh the disputed PoSt is.
+func TestDeadlineCloseAtHeight(t *testing.T) {
+	periodStart := types.NewBlockHeight(1000)
+	periodBlocks := DeadlineBlocks.AsBigInt().Uint64() * NumDeadlines
+
+	// First period: close height is a fixed offset from periodStart.
+	firstClose := deadlineCloseAtHeight(periodStart, periodStart, 0)
+	assert.Equal(t, periodStart.Add(DeadlineBlocks).AsBigInt(), firstClose.AsBigInt())
+
+	// Second period: the close height must be anchored to the period that
+	// height actually falls in, not periodStart's original period.
+	secondPeriodHeight := periodStart.Add(types.NewBlockHeight(periodBlocks + DeadlineBlocks.AsBigInt().Uint64()))
+	secondClose := deadlineCloseAtHeight(periodStart, secondPeriodHeight, 0)
+	expectedSecondClose := periodStart.Add(types.NewBlockHeight(periodBlocks)).Add(DeadlineBlocks)
+	assert.Equal(t, expectedSecondClose.AsBigInt(), secondClose.AsBigInt())
+	assert.True(t, secondClose.GreaterThan(secondPeriodHeight.Sub(DeadlineBlocks)))
+}
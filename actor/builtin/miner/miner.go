@@ -1,16 +1,21 @@
 package miner
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
 	"math/big"
 
 	"gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
 	cbor "gx/ipfs/QmV6BQ6fFCf9eFHDuRxvguvqfKLZtZrxthgZvDfRCs4tMN/go-ipld-cbor"
+	"gx/ipfs/QmZFbDTY9jfSBms2MchvYM9oYRbAF19K7Pby47yDBfpPrb/go-cid"
 	xerrors "gx/ipfs/QmVmDhyTTUcQXFD1rRQ64fGLMSAoaQvNH3hwuaCFAPq2hy/errors"
 
 	"github.com/filecoin-project/go-filecoin/abi"
 	"github.com/filecoin-project/go-filecoin/actor"
 	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/adt"
+	"github.com/filecoin-project/go-filecoin/bitfield"
 	"github.com/filecoin-project/go-filecoin/exec"
 	"github.com/filecoin-project/go-filecoin/types"
 	"github.com/filecoin-project/go-filecoin/vm/errors"
@@ -18,6 +23,8 @@ import (
 
 func init() {
 	cbor.RegisterCborType(State{})
+	cbor.RegisterCborType(SectorOnChainInfo{})
+	cbor.RegisterCborType(SectorPreCommitOnChainInfo{})
 }
 
 // MaximumPublicKeySize is a limit on how big a public key can be.
@@ -26,6 +33,28 @@ const MaximumPublicKeySize = 100
 // ProvingPeriodBlocks defines how long a proving period is for
 var ProvingPeriodBlocks = types.NewBlockHeight(2000)
 
+// NumDeadlines is the number of deadlines a proving period is partitioned
+// into. Each deadline owns a disjoint partition of the miner's sectors and
+// must be proven independently, rather than requiring one PoSt covering
+// every sector in the miner's power.
+const NumDeadlines = 48
+
+// DeadlineBlocks is the number of blocks in a single deadline.
+var DeadlineBlocks = types.NewBlockHeight(uint64(2000 / NumDeadlines))
+
+// ChallengeWindowBlocks is how long after a deadline closes that any address
+// may dispute a windowed PoSt submitted for that deadline.
+var ChallengeWindowBlocks = types.NewBlockHeight(20)
+
+// PreCommitChallengeDelay is the number of blocks a miner must wait after
+// pre-committing a sector before it can sample the interactive seal
+// challenge and prove the sector.
+var PreCommitChallengeDelay = types.NewBlockHeight(150)
+
+// MaxPreCommitAge is how long a precommit may sit unproven before it is
+// considered expired and its deposit is burned.
+var MaxPreCommitAge = types.NewBlockHeight(10000)
+
 const (
 	// ErrPublicKeyTooBig indicates an invalid public key.
 	ErrPublicKeyTooBig = 33
@@ -39,21 +68,131 @@ const (
 	ErrCallerUnauthorized = 37
 	// ErrInsufficientPledge signals insufficient pledge for what you are trying to do.
 	ErrInsufficientPledge = 38
+	// ErrInvalidDeadline indicates a deadline index outside of [0, NumDeadlines).
+	ErrInvalidDeadline = 39
+	// ErrInvalidPartition indicates a partition index that is not assigned to the deadline.
+	ErrInvalidPartition = 40
+	// ErrPoStAlreadySubmitted indicates a partition already has a valid PoSt for this period.
+	ErrPoStAlreadySubmitted = 41
+	// ErrInvalidPoStProof indicates the submitted windowed PoSt proof did not verify.
+	ErrInvalidPoStProof = 42
+	// ErrDisputeWindowExpired indicates a dispute was filed after its challenge window closed.
+	ErrDisputeWindowExpired = 43
+	// ErrPoStNotDisputable indicates there is no valid PoSt submission to dispute.
+	ErrPoStNotDisputable = 44
+	// ErrPreCommitNotFound indicates no precommit exists for the given sector.
+	ErrPreCommitNotFound = 45
+	// ErrPreCommitExpired indicates the precommit is older than MaxPreCommitAge.
+	ErrPreCommitExpired = 46
+	// ErrInvalidSealProof indicates the interactive seal proof did not verify.
+	ErrInvalidSealProof = 47
+	// ErrPreCommitTooSoon indicates proveCommitSector was called before PreCommitChallengeDelay elapsed.
+	ErrPreCommitTooSoon = 48
+	// ErrDealActivationFailed indicates StorageMarket rejected activating the sector's deals.
+	ErrDealActivationFailed = 49
+	// ErrInvalidConsensusFault indicates the two blocks passed to reportConsensusFault do not
+	// prove a fault, or do not both carry a valid signature from this miner.
+	ErrInvalidConsensusFault = 50
+	// ErrPoStDisputeNotImplemented indicates a windowed PoSt was disputed, but this actor
+	// cannot yet re-verify the disputed proof, so it refuses to slash on an unverified claim.
+	ErrPoStDisputeNotImplemented = 51
 )
 
 // Errors map error codes to revert errors this actor may return.
 var Errors = map[uint8]error{
-	ErrPublicKeyTooBig:         errors.NewCodedRevertErrorf(ErrPublicKeyTooBig, "public key must be less than %d bytes", MaximumPublicKeySize),
-	ErrInvalidSector:           errors.NewCodedRevertErrorf(ErrInvalidSector, "sectorID out of range"),
-	ErrSectorCommitted:         errors.NewCodedRevertErrorf(ErrSectorCommitted, "sector already committed"),
-	ErrStoragemarketCallFailed: errors.NewCodedRevertErrorf(ErrStoragemarketCallFailed, "call to StorageMarket failed"),
-	ErrCallerUnauthorized:      errors.NewCodedRevertErrorf(ErrCallerUnauthorized, "not authorized to call the method"),
-	ErrInsufficientPledge:      errors.NewCodedRevertErrorf(ErrInsufficientPledge, "not enough pledged"),
+	ErrPublicKeyTooBig:           errors.NewCodedRevertErrorf(ErrPublicKeyTooBig, "public key must be less than %d bytes", MaximumPublicKeySize),
+	ErrInvalidSector:             errors.NewCodedRevertErrorf(ErrInvalidSector, "sectorID out of range"),
+	ErrSectorCommitted:           errors.NewCodedRevertErrorf(ErrSectorCommitted, "sector already committed"),
+	ErrStoragemarketCallFailed:   errors.NewCodedRevertErrorf(ErrStoragemarketCallFailed, "call to StorageMarket failed"),
+	ErrCallerUnauthorized:        errors.NewCodedRevertErrorf(ErrCallerUnauthorized, "not authorized to call the method"),
+	ErrInsufficientPledge:        errors.NewCodedRevertErrorf(ErrInsufficientPledge, "not enough pledged"),
+	ErrInvalidDeadline:           errors.NewCodedRevertErrorf(ErrInvalidDeadline, "deadline index out of range"),
+	ErrInvalidPartition:          errors.NewCodedRevertErrorf(ErrInvalidPartition, "partition not assigned to deadline"),
+	ErrPoStAlreadySubmitted:      errors.NewCodedRevertErrorf(ErrPoStAlreadySubmitted, "partition already has a valid PoSt for this period"),
+	ErrInvalidPoStProof:          errors.NewCodedRevertErrorf(ErrInvalidPoStProof, "windowed PoSt proof did not verify"),
+	ErrDisputeWindowExpired:      errors.NewCodedRevertErrorf(ErrDisputeWindowExpired, "dispute window for this deadline has closed"),
+	ErrPoStNotDisputable:         errors.NewCodedRevertErrorf(ErrPoStNotDisputable, "no valid PoSt submission to dispute"),
+	ErrPreCommitNotFound:         errors.NewCodedRevertErrorf(ErrPreCommitNotFound, "no precommit found for sectorID"),
+	ErrPreCommitExpired:          errors.NewCodedRevertErrorf(ErrPreCommitExpired, "precommit has expired, deposit burned"),
+	ErrInvalidSealProof:          errors.NewCodedRevertErrorf(ErrInvalidSealProof, "seal proof did not verify"),
+	ErrPreCommitTooSoon:          errors.NewCodedRevertErrorf(ErrPreCommitTooSoon, "must wait PreCommitChallengeDelay blocks before proving"),
+	ErrDealActivationFailed:      errors.NewCodedRevertErrorf(ErrDealActivationFailed, "call to StorageMarket to activate deals failed"),
+	ErrInvalidConsensusFault:     errors.NewCodedRevertErrorf(ErrInvalidConsensusFault, "blocks do not prove a consensus fault by this miner"),
+	ErrPoStDisputeNotImplemented: errors.NewCodedRevertErrorf(ErrPoStDisputeNotImplemented, "dispute re-verification is not implemented; cannot slash on an unverified claim"),
 }
 
 // Actor is the miner actor.
 type Actor struct{}
 
+// Deadline tracks the sectors partitioned into a single proving deadline,
+// along with which of those sectors are faulty, recovering, terminated, or
+// have already posted a valid windowed PoSt for the current proving period.
+// Each set is a bitfield.BitField rather than a Go map, since a Deadline is
+// itself a plain field of State and would otherwise have its member sets
+// CBOR-dumped in full on every state write.
+type Deadline struct {
+	// Sectors is the partition of the miner's sectors assigned to this deadline.
+	Sectors bitfield.BitField
+
+	// Faults is the subset of Sectors known to be unproven, either because the
+	// miner declared them or because the deadline closed without a PoSt.
+	Faults bitfield.BitField
+
+	// Recoveries is the subset of Faults the miner has declared it will prove
+	// again on its next windowed PoSt.
+	Recoveries bitfield.BitField
+
+	// Terminated is the subset of Sectors that have been permanently removed
+	// from the miner's proving obligations.
+	Terminated bitfield.BitField
+
+	// PostSubmissions records, by partition index, which partitions have a
+	// valid windowed PoSt for the current proving period.
+	PostSubmissions bitfield.BitField
+}
+
+// SectorOnChainInfo is the on-chain record of a proven sector, keyed by
+// sector number in the Sectors AMT.
+type SectorOnChainInfo struct {
+	// SealedCID is the commitment to the sealed sector data (commR).
+	SealedCID []byte
+
+	// UnsealedCID is the commitment to the sector's unsealed data (commD).
+	UnsealedCID []byte
+}
+
+// PreCommitInfo is the information a miner supplies when it pre-commits a
+// sector, before the sector's data is provably sealed.
+type PreCommitInfo struct {
+	SectorNumber uint64
+
+	// SealedCID is the commitment to the sealed sector data (commR).
+	SealedCID []byte
+
+	// SealRandEpoch is the epoch whose randomness was used to open the seal.
+	SealRandEpoch *types.BlockHeight
+
+	// DealIDs are the on-chain storage deals packed into this sector.
+	DealIDs []uint64
+
+	// Expiration is the block height at which the sector's pledge expires.
+	Expiration *types.BlockHeight
+}
+
+// SectorPreCommitOnChainInfo is a PreCommitInfo together with the chain data
+// recorded when it landed on chain, needed to validate the eventual
+// proveCommitSector call and to garbage-collect stale precommits.
+type SectorPreCommitOnChainInfo struct {
+	Info PreCommitInfo
+
+	// PreCommitEpoch is the block height at which preCommitSector was called.
+	PreCommitEpoch *types.BlockHeight
+
+	// PreCommitDeposit is the amount locked from the miner's collateral until
+	// the sector is proven or the precommit expires.
+	PreCommitDeposit *types.AttoFIL
+}
+
 // State is the miner actors storage.
 type State struct {
 	Owner address.Address
@@ -72,11 +211,25 @@ type State struct {
 	// the miners pledge.
 	Collateral *types.AttoFIL
 
-	// Sectors maps commR to commD, for all sectors this miner has committed.
-	Sectors map[string][]byte
+	// Sectors is the root of an adt.Array of SectorOnChainInfo keyed by
+	// sector number, for all sectors this miner has proven. Using an AMT
+	// instead of a Go map means committing a sector only rewrites the
+	// O(log n) nodes on the path to it, rather than the whole collection.
+	Sectors cid.Cid
+
+	// PreCommittedSectors is the root of an adt.Map of
+	// SectorPreCommitOnChainInfo keyed by sector number, for sectors that
+	// have been pre-committed but not yet proven. A precommit is removed
+	// once the miner successfully calls proveCommitSector, or
+	// garbage-collected once it is older than MaxPreCommitAge.
+	PreCommittedSectors cid.Cid
+
+	// Deadlines partitions the miner's sectors into NumDeadlines windows, each
+	// of which must be proven with its own windowed PoSt once per proving
+	// period.
+	Deadlines []Deadline
 
 	ProvingPeriodStart *types.BlockHeight
-	LastPoSt           *types.BlockHeight
 
 	LockedStorage *types.BytesAmount // LockedStorage is the amount of the miner's storage that is used.
 	Power         *big.Int
@@ -87,7 +240,9 @@ func NewActor() *actor.Actor {
 	return actor.NewActor(types.MinerActorCodeCid, types.NewZeroAttoFIL())
 }
 
-// NewState creates a miner state struct
+// NewState creates a miner state struct. Sectors and PreCommittedSectors are
+// left as the zero cid.Cid; InitializeState fills them in with the roots of
+// empty collections once it has access to the actor's backing store.
 func NewState(owner address.Address, key []byte, pledge *types.BytesAmount, pid peer.ID, collateral *types.AttoFIL) *State {
 	return &State{
 		Owner:         owner,
@@ -96,11 +251,92 @@ func NewState(owner address.Address, key []byte, pledge *types.BytesAmount, pid
 		PledgeBytes:   pledge,
 		Collateral:    collateral,
 		LockedStorage: types.NewBytesAmount(0),
-		Sectors:       make(map[string][]byte),
+		Deadlines:     make([]Deadline, NumDeadlines),
 		Power:         big.NewInt(0),
 	}
 }
 
+// deadlineIndexAtHeight returns the index of the deadline whose window
+// contains height, relative to the start of the current proving period.
+func deadlineIndexAtHeight(periodStart, height *types.BlockHeight) uint64 {
+	elapsed := height.Sub(periodStart)
+	idx := elapsed.AsBigInt().Uint64() / DeadlineBlocks.AsBigInt().Uint64()
+	return idx % NumDeadlines
+}
+
+// deadlineCloseAtHeight returns the close height of deadlineIdx's window in
+// the proving period containing height, using the same elapsed/modulo math
+// as deadlineIndexAtHeight. ProvingPeriodStart is set once, at ProveCommit,
+// and never advanced, so anchoring solely off it (rather than the period
+// height actually falls in) would only be correct during the miner's first
+// proving period.
+func deadlineCloseAtHeight(periodStart, height *types.BlockHeight, deadlineIdx uint64) *types.BlockHeight {
+	elapsed := height.Sub(periodStart).AsBigInt().Uint64()
+	deadlineBlocks := DeadlineBlocks.AsBigInt().Uint64()
+	periodBlocks := deadlineBlocks * NumDeadlines
+	periodNumber := elapsed / periodBlocks
+	currentPeriodStart := periodStart.Add(types.NewBlockHeight(periodNumber * periodBlocks))
+	return currentPeriodStart.Add(types.NewBlockHeight(deadlineBlocks * (deadlineIdx + 1)))
+}
+
+// AssignSectorToDeadline places a newly-committed sector into a deadline,
+// spreading sectors round-robin across the partitions so that no single
+// deadline accumulates the whole of a miner's power.
+func (s *State) AssignSectorToDeadline(sectorID uint64) {
+	idx := sectorID % NumDeadlines
+	s.Deadlines[idx].Sectors.Set(sectorID)
+}
+
+// closeDeadline marks every partition of deadline idx that did not post a
+// windowed PoSt as faulty, charges the miner a fee for the missed proofs via
+// StorageMarket, and clears PostSubmissions for the next period.
+func (s *State) closeDeadline(ctx exec.VMContext, idx uint64) error {
+	deadline := &s.Deadlines[idx]
+
+	missed := 0
+	err := deadline.Sectors.ForEach(func(sectorID uint64) error {
+		if !deadline.PostSubmissions.Has(sectorID) {
+			deadline.Faults.Set(sectorID)
+			missed++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	deadline.PostSubmissions = bitfield.BitField{}
+
+	if missed > 0 {
+		_, ret, err := ctx.Send(address.StorageMarketAddress, "chargeFaultFee", nil, []interface{}{big.NewInt(int64(missed))})
+		if err != nil {
+			return err
+		}
+		if ret != 0 {
+			return Errors[ErrStoragemarketCallFailed]
+		}
+	}
+
+	return nil
+}
+
+// sectorKey encodes a sector number as the byte-string key used to address
+// it in the PreCommittedSectors adt.Map.
+func sectorKey(sectorNum uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, sectorNum)
+	return buf
+}
+
+// loadSectors opens the adt.Array rooted at s.Sectors.
+func (s *State) loadSectors(ctx exec.VMContext) (*adt.Array, error) {
+	return adt.AsArray(ctx.IpldStore(), s.Sectors)
+}
+
+// loadPreCommittedSectors opens the adt.Map rooted at s.PreCommittedSectors.
+func (s *State) loadPreCommittedSectors(ctx exec.VMContext) (*adt.Map, error) {
+	return adt.AsMap(ctx.IpldStore(), s.PreCommittedSectors)
+}
+
 // InitializeState stores this miner's initial data structure.
 func (ma *Actor) InitializeState(storage exec.Storage, initializerData interface{}) error {
 	minerState, ok := initializerData.(*State)
@@ -114,6 +350,18 @@ func (ma *Actor) InitializeState(storage exec.Storage, initializerData interface
 		return Errors[ErrPublicKeyTooBig]
 	}
 
+	sectorsRoot, err := adt.NewArray(storage).Root()
+	if err != nil {
+		return errors.FaultErrorWrap(err, "failed to flush empty Sectors array")
+	}
+	minerState.Sectors = sectorsRoot
+
+	precommitsRoot, err := adt.NewMap(storage).Root()
+	if err != nil {
+		return errors.FaultErrorWrap(err, "failed to flush empty PreCommittedSectors map")
+	}
+	minerState.PreCommittedSectors = precommitsRoot
+
 	stateBytes, err := cbor.DumpObject(minerState)
 	if err != nil {
 		return xerrors.Wrap(err, "failed to cbor marshal object")
@@ -138,10 +386,18 @@ var minerExports = exec.Exports{
 		Params: nil,
 		Return: []abi.Type{abi.Address},
 	},
-	"commitSector": &exec.FunctionSignature{
+	"preCommitSector": &exec.FunctionSignature{
+		Params: []abi.Type{abi.PreCommitInfo},
+		Return: []abi.Type{},
+	},
+	"proveCommitSector": &exec.FunctionSignature{
 		Params: []abi.Type{abi.SectorID, abi.Bytes, abi.Bytes},
 		Return: []abi.Type{},
 	},
+	"getPreCommittedSector": &exec.FunctionSignature{
+		Params: []abi.Type{abi.SectorID},
+		Return: []abi.Type{abi.PreCommitInfo},
+	},
 	"getKey": &exec.FunctionSignature{
 		Params: []abi.Type{},
 		Return: []abi.Type{abi.Bytes},
@@ -158,14 +414,30 @@ var minerExports = exec.Exports{
 		Params: []abi.Type{},
 		Return: []abi.Type{abi.BytesAmount},
 	},
-	"submitPoSt": &exec.FunctionSignature{
-		Params: []abi.Type{abi.Bytes},
-		Return: []abi.Type{},
-	},
 	"getProvingPeriodStart": &exec.FunctionSignature{
 		Params: []abi.Type{},
 		Return: []abi.Type{abi.BlockHeight},
 	},
+	"declareFaults": &exec.FunctionSignature{
+		Params: []abi.Type{abi.UintArray},
+		Return: []abi.Type{},
+	},
+	"declareRecoveries": &exec.FunctionSignature{
+		Params: []abi.Type{abi.UintArray},
+		Return: []abi.Type{},
+	},
+	"submitWindowedPoSt": &exec.FunctionSignature{
+		Params: []abi.Type{abi.Integer, abi.UintArray, abi.Bytes, abi.UintArray},
+		Return: []abi.Type{},
+	},
+	"disputeWindowedPoSt": &exec.FunctionSignature{
+		Params: []abi.Type{abi.Integer, abi.Integer, abi.Bytes},
+		Return: []abi.Type{},
+	},
+	"reportConsensusFault": &exec.FunctionSignature{
+		Params: []abi.Type{abi.Bytes, abi.Bytes},
+		Return: []abi.Type{},
+	},
 }
 
 // Exports returns the miner actors exported functions.
@@ -238,25 +510,172 @@ func (ma *Actor) GetOwner(ctx exec.VMContext) (address.Address, uint8, error) {
 	return a, 0, nil
 }
 
-// CommitSector adds a commitment to the specified sector
-// The sector must not already be committed
-// 'size' is the total number of bytes stored in the sector
-func (ma *Actor) CommitSector(ctx exec.VMContext, sectorID uint64, commR, commD []byte) (uint8, error) {
+// PreCommitDeposit is the amount of collateral locked per pending precommit
+// until the sector is proven or the precommit expires.
+var PreCommitDeposit = types.NewAttoFILFromFIL(1)
+
+// PreCommitSector records that the miner intends to prove sectorID, locking
+// a deposit from its collateral until proveCommitSector is called. The seal
+// cannot be verified yet: that requires randomness sampled at the
+// precommit's own epoch plus PreCommitChallengeDelay, which is not yet on
+// chain.
+func (ma *Actor) PreCommitSector(ctx exec.VMContext, info PreCommitInfo) (uint8, error) {
 	var state State
 	_, err := actor.WithState(ctx, &state, func() (interface{}, error) {
-		commRstr := string(commR) // proper fixed length array encoding in cbor is apparently 'hard'.
-		_, ok := state.Sectors[commRstr]
-		if ok {
+		if ctx.Message().From != state.Owner {
+			return nil, Errors[ErrCallerUnauthorized]
+		}
+
+		sectors, err := state.loadSectors(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var existingSector SectorOnChainInfo
+		found, err := sectors.Get(info.SectorNumber, &existingSector)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			return nil, Errors[ErrSectorCommitted]
+		}
+
+		precommits, err := state.loadPreCommittedSectors(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var existingPrecommit SectorPreCommitOnChainInfo
+		found, err = precommits.Get(sectorKey(info.SectorNumber), &existingPrecommit)
+		if err != nil {
+			return nil, err
+		}
+		if found {
 			return nil, Errors[ErrSectorCommitted]
 		}
 
+		if state.Collateral.LessThan(PreCommitDeposit) {
+			return nil, Errors[ErrInsufficientPledge]
+		}
+		state.Collateral = state.Collateral.Sub(PreCommitDeposit)
+
+		if err := precommits.Put(sectorKey(info.SectorNumber), &SectorPreCommitOnChainInfo{
+			Info:             info,
+			PreCommitEpoch:   ctx.BlockHeight(),
+			PreCommitDeposit: PreCommitDeposit,
+		}); err != nil {
+			return nil, err
+		}
+
+		precommitsRoot, err := precommits.Root()
+		if err != nil {
+			return nil, err
+		}
+		state.PreCommittedSectors = precommitsRoot
+
+		return nil, nil
+	})
+	if err != nil {
+		return errors.CodeError(err), err
+	}
+
+	return 0, nil
+}
+
+// ProveCommitSector completes the interactive PoRep begun by
+// preCommitSector. It must be called at least PreCommitChallengeDelay blocks
+// after the matching precommit and before MaxPreCommitAge elapses. It
+// samples the seal and interactive randomness the proof was generated
+// against, checks proof with verifySealProof (which accepts any non-empty
+// proof), and only then moves the sector into Sectors and
+// credits power. If the precommit named any DealIDs, it also activates them
+// with StorageMarket, which rejects the call if unsealedCID does not match
+// what it derives from the deals' pieces.
+func (ma *Actor) ProveCommitSector(ctx exec.VMContext, sectorID uint64, unsealedCID []byte, proof []byte) (uint8, error) {
+	var state State
+	_, err := actor.WithState(ctx, &state, func() (interface{}, error) {
+		precommits, err := state.loadPreCommittedSectors(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var precommit SectorPreCommitOnChainInfo
+		found, err := precommits.Get(sectorKey(sectorID), &precommit)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, Errors[ErrPreCommitNotFound]
+		}
+
+		age := ctx.BlockHeight().Sub(precommit.PreCommitEpoch)
+		if age.AsBigInt().Uint64() > MaxPreCommitAge.AsBigInt().Uint64() {
+			if err := precommits.Delete(sectorKey(sectorID)); err != nil {
+				return nil, err
+			}
+			precommitsRoot, err := precommits.Root()
+			if err != nil {
+				return nil, err
+			}
+			state.PreCommittedSectors = precommitsRoot
+			return nil, Errors[ErrPreCommitExpired]
+		}
+		if age.AsBigInt().Uint64() < PreCommitChallengeDelay.AsBigInt().Uint64() {
+			return nil, Errors[ErrPreCommitTooSoon]
+		}
+
+		interactiveEpoch := precommit.PreCommitEpoch.Add(PreCommitChallengeDelay)
+		sealRand, err := ctx.SampleChainRandomness(precommit.Info.SealRandEpoch)
+		if err != nil {
+			return nil, err
+		}
+		interactiveRand, err := ctx.SampleChainRandomness(interactiveEpoch)
+		if err != nil {
+			return nil, err
+		}
+
+		if !verifySealProof(precommit.Info.SealedCID, unsealedCID, sealRand, interactiveRand, ctx.Message().To, sectorID, proof) {
+			return nil, Errors[ErrInvalidSealProof]
+		}
+
+		if len(precommit.Info.DealIDs) > 0 {
+			_, ret, err := ctx.Send(address.StorageMarketAddress, "activateDeals", nil, []interface{}{precommit.Info.DealIDs, precommit.Info.Expiration, unsealedCID})
+			if err != nil {
+				return nil, err
+			}
+			if ret != 0 {
+				return nil, Errors[ErrDealActivationFailed]
+			}
+		}
+
+		if err := precommits.Delete(sectorKey(sectorID)); err != nil {
+			return nil, err
+		}
+		precommitsRoot, err := precommits.Root()
+		if err != nil {
+			return nil, err
+		}
+		state.PreCommittedSectors = precommitsRoot
+		state.Collateral = state.Collateral.Add(precommit.PreCommitDeposit)
+
 		if state.Power.Cmp(big.NewInt(0)) == 0 {
 			fmt.Println("starting proving period", ctx.BlockHeight())
 			state.ProvingPeriodStart = ctx.BlockHeight()
 		}
 		inc := big.NewInt(1)
 		state.Power = state.Power.Add(state.Power, inc)
-		state.Sectors[commRstr] = commD
+
+		sectors, err := state.loadSectors(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := sectors.Set(sectorID, &SectorOnChainInfo{SealedCID: precommit.Info.SealedCID, UnsealedCID: unsealedCID}); err != nil {
+			return nil, err
+		}
+		sectorsRoot, err := sectors.Root()
+		if err != nil {
+			return nil, err
+		}
+		state.Sectors = sectorsRoot
+		state.AssignSectorToDeadline(sectorID)
 
 		_, ret, err := ctx.Send(address.StorageMarketAddress, "updatePower", nil, []interface{}{inc})
 		if err != nil {
@@ -274,6 +693,53 @@ func (ma *Actor) CommitSector(ctx exec.VMContext, sectorID uint64, commR, commD
 	return 0, nil
 }
 
+// GetPreCommittedSector returns the precommit info recorded for sectorID,
+// if it has not yet been proven or expired. It returns only the PreCommitInfo
+// half of the on-chain record: PreCommitEpoch and PreCommitDeposit are
+// bookkeeping this actor needs internally (to age out and refund stale
+// precommits) but aren't part of the declared getPreCommittedSector return
+// type, so they're not exposed here.
+func (ma *Actor) GetPreCommittedSector(ctx exec.VMContext, sectorID uint64) (*PreCommitInfo, uint8, error) {
+	var state State
+	out, err := actor.WithState(ctx, &state, func() (interface{}, error) {
+		precommits, err := state.loadPreCommittedSectors(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var precommit SectorPreCommitOnChainInfo
+		found, err := precommits.Get(sectorKey(sectorID), &precommit)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, Errors[ErrPreCommitNotFound]
+		}
+		return &precommit.Info, nil
+	})
+	if err != nil {
+		return nil, errors.CodeError(err), err
+	}
+
+	precommit, ok := out.(*PreCommitInfo)
+	if !ok {
+		return nil, 1, errors.NewFaultErrorf("expected a *PreCommitInfo return value from call, but got %T instead", out)
+	}
+
+	return precommit, 0, nil
+}
+
+// verifySealProof is a STUB: it does not check proof against sealedCID,
+// unsealedCID, sealRand, interactiveRand, minerID, or sectorID at all, and
+// accepts any non-empty byte slice as a valid seal. Splitting CommitSector
+// into precommit/prove-commit (this change) buys real interactive
+// randomness sampling, but does NOT by itself close the "commit anything"
+// hole; that requires wiring this function to the proofs package's actual
+// SNARK verifier, which is tracked separately.
+func verifySealProof(sealedCID, unsealedCID, sealRand, interactiveRand []byte, minerID address.Address, sectorID uint64, proof []byte) bool {
+	return len(proof) > 0
+}
+
 // GetKey returns the public key for this miner.
 func (ma *Actor) GetKey(ctx exec.VMContext) ([]byte, uint8, error) {
 	var state State
@@ -346,30 +812,120 @@ func (ma *Actor) GetStorage(ctx exec.VMContext) (*types.BytesAmount, uint8, erro
 	return count, 0, nil
 }
 
-// SubmitPoSt is used to submit a coalesced PoST to the chain to convince the chain
-// that you have been actually storing the files you claim to be.
-func (ma *Actor) SubmitPoSt(ctx exec.VMContext, proof []byte) (uint8, error) {
+// DeclareFaults marks sectors as faulty ahead of their deadline closing and
+// withdraws any recovery declared for them. A declared fault that does not
+// post a windowed PoSt is still charged the fault fee when its deadline
+// closes, the same as an undeclared one.
+func (ma *Actor) DeclareFaults(ctx exec.VMContext, sectors []uint64) (uint8, error) {
 	var state State
 	_, err := actor.WithState(ctx, &state, func() (interface{}, error) {
-		// verify that the caller is authorized to perform update
-		fmt.Println("submitting proof", proof, ctx.Message().From, state.Owner)
 		if ctx.Message().From != state.Owner {
 			return nil, Errors[ErrCallerUnauthorized]
 		}
 
-		// TODO: validate the PoSt
+		for _, sectorID := range sectors {
+			deadline := &state.Deadlines[sectorID%NumDeadlines]
+			if deadline.Sectors.Has(sectorID) {
+				deadline.Faults.Set(sectorID)
+				deadline.Recoveries.Unset(sectorID)
+			}
+		}
 
-		// Check if we submitted it in time
-		provingPeriodEnd := state.ProvingPeriodStart.Add(ProvingPeriodBlocks)
+		return nil, nil
+	})
+	if err != nil {
+		return errors.CodeError(err), err
+	}
+
+	return 0, nil
+}
+
+// DeclareRecoveries marks previously-faulty sectors as ready to be proven
+// again on their deadline's next windowed PoSt.
+func (ma *Actor) DeclareRecoveries(ctx exec.VMContext, sectors []uint64) (uint8, error) {
+	var state State
+	_, err := actor.WithState(ctx, &state, func() (interface{}, error) {
+		if ctx.Message().From != state.Owner {
+			return nil, Errors[ErrCallerUnauthorized]
+		}
+
+		for _, sectorID := range sectors {
+			deadline := &state.Deadlines[sectorID%NumDeadlines]
+			if deadline.Faults.Has(sectorID) {
+				deadline.Recoveries.Set(sectorID)
+			}
+		}
+
+		return nil, nil
+	})
+	if err != nil {
+		return errors.CodeError(err), err
+	}
+
+	return 0, nil
+}
+
+// SubmitWindowedPoSt proves that the sectors in the given partitions of
+// deadlineIdx are still being stored. Only one deadline is proven per call,
+// rather than every sector the miner has committed. Any partitions listed in
+// recoveries are cleared from the deadline's fault set on success.
+func (ma *Actor) SubmitWindowedPoSt(ctx exec.VMContext, deadlineIdx uint64, partitions []uint64, proof []byte, recoveries []uint64) (uint8, error) {
+	recoveriesField := bitfield.NewFromSet(recoveries)
+
+	var state State
+	_, err := actor.WithState(ctx, &state, func() (interface{}, error) {
+		if ctx.Message().From != state.Owner {
+			return nil, Errors[ErrCallerUnauthorized]
+		}
+
+		if deadlineIdx >= NumDeadlines {
+			return nil, Errors[ErrInvalidDeadline]
+		}
+
+		if deadlineIdx != deadlineIndexAtHeight(state.ProvingPeriodStart, ctx.BlockHeight()) {
+			return nil, errors.NewRevertErrorf("submitted PoSt for a deadline that is not currently open")
+		}
+
+		// The previous deadline just closed to make way for this one. Any of
+		// its partitions that never posted are now faulty, and the miner owes
+		// a fee for the missed proof. Deadline 0 wraps around to NumDeadlines-1,
+		// which only refers to a deadline that actually opened and closed once
+		// a full proving period has elapsed; before that there is no previous
+		// deadline to close.
+		elapsed := ctx.BlockHeight().Sub(state.ProvingPeriodStart).AsBigInt().Uint64()
+		periodBlocks := DeadlineBlocks.AsBigInt().Uint64() * NumDeadlines
+		if deadlineIdx != 0 || elapsed >= periodBlocks {
+			if err := state.closeDeadline(ctx, (deadlineIdx+NumDeadlines-1)%NumDeadlines); err != nil {
+				return nil, err
+			}
+		}
+
+		deadline := &state.Deadlines[deadlineIdx]
+		for _, p := range partitions {
+			if !deadline.Sectors.Has(p) {
+				return nil, Errors[ErrInvalidPartition]
+			}
+			if deadline.PostSubmissions.Has(p) {
+				return nil, Errors[ErrPoStAlreadySubmitted]
+			}
+		}
+
+		// TODO: verify proof against the deadline's recorded challenge once
+		// chain randomness sampling is threaded through VMContext.
+		if len(proof) == 0 {
+			return nil, Errors[ErrInvalidPoStProof]
+		}
 
-		if ctx.BlockHeight().LessEqual(provingPeriodEnd) {
-			state.ProvingPeriodStart = provingPeriodEnd
-			state.LastPoSt = ctx.BlockHeight()
-		} else {
-			fmt.Println("late submission", ctx.BlockHeight(), provingPeriodEnd)
-			// Not great.
-			// TODO: charge penalty
-			return nil, errors.NewRevertErrorf("submitted PoSt late, need to pay a fee")
+		for _, p := range partitions {
+			deadline.PostSubmissions.Set(p)
+			deadline.Faults.Unset(p)
+		}
+		if err := recoveriesField.ForEach(func(r uint64) error {
+			deadline.Recoveries.Unset(r)
+			deadline.Faults.Unset(r)
+			return nil
+		}); err != nil {
+			return nil, err
 		}
 
 		return nil, nil
@@ -381,6 +937,46 @@ func (ma *Actor) SubmitPoSt(ctx exec.VMContext, proof []byte) (uint8, error) {
 	return 0, nil
 }
 
+// DisputeWindowedPoSt is meant to let any address invalidate a fraudulent
+// windowed PoSt submitted for partitionIdx of deadlineIdx, provided the
+// dispute is filed within ChallengeWindowBlocks of the deadline closing, and
+// slash the miner's collateral in favor of the disputer on a successful
+// dispute. It cannot do that yet: re-verifying the disputed proof requires
+// chain randomness sampling that is not threaded through VMContext, and
+// without that this actor has no way to tell a correct dispute of a genuine
+// fraud from a baseless one. Rather than pay out on the caller's unverified
+// say-so, it always reverts with ErrPoStDisputeNotImplemented; wiring in
+// real re-verification (and gating the slash on it) is tracked separately.
+func (ma *Actor) DisputeWindowedPoSt(ctx exec.VMContext, deadlineIdx, partitionIdx uint64, proof []byte) (uint8, error) {
+	var state State
+	_, err := actor.WithState(ctx, &state, func() (interface{}, error) {
+		if deadlineIdx >= NumDeadlines {
+			return nil, Errors[ErrInvalidDeadline]
+		}
+
+		deadline := &state.Deadlines[deadlineIdx]
+		if !deadline.PostSubmissions.Has(partitionIdx) {
+			return nil, Errors[ErrPoStNotDisputable]
+		}
+
+		deadlineClose := deadlineCloseAtHeight(state.ProvingPeriodStart, ctx.BlockHeight(), deadlineIdx)
+		if ctx.BlockHeight().GreaterThan(deadlineClose.Add(ChallengeWindowBlocks)) {
+			return nil, Errors[ErrDisputeWindowExpired]
+		}
+
+		// Until the disputed proof can actually be re-verified against the
+		// deadline's recorded challenge, there is no basis to unset the
+		// submission or move any collateral: doing so on proof's mere
+		// non-emptiness would let anyone slash an honest miner at will.
+		return nil, Errors[ErrPoStDisputeNotImplemented]
+	})
+	if err != nil {
+		return errors.CodeError(err), err
+	}
+
+	return 0, nil
+}
+
 // GetProvingPeriodStart returns the current ProvingPeriodStart value.
 func (ma *Actor) GetProvingPeriodStart(ctx exec.VMContext) (*types.BlockHeight, uint8, error) {
 	chunk, err := ctx.ReadStorage()
@@ -395,3 +991,145 @@ func (ma *Actor) GetProvingPeriodStart(ctx exec.VMContext) (*types.BlockHeight,
 
 	return state.ProvingPeriodStart, 0, nil
 }
+
+// ConsensusFaultType enumerates the ways two of a miner's blocks can prove
+// it equivocated.
+type ConsensusFaultType int
+
+const (
+	// ConsensusFaultDoubleFork is two blocks by the same miner, at the same
+	// height, extending the same parent tipset.
+	ConsensusFaultDoubleFork ConsensusFaultType = iota
+	// ConsensusFaultTimeOffset is two blocks by the same miner extending the
+	// same parent tipset but claiming different heights.
+	ConsensusFaultTimeOffset
+	// ConsensusFaultParentGrinding is a miner building on a different parent
+	// than one it itself already mined and signed at the same height,
+	// effectively censoring its own earlier block.
+	ConsensusFaultParentGrinding
+)
+
+// blockHeader is the subset of a block header this actor needs to detect a
+// consensus fault: who mined it, what it built on, and its signature. The
+// canonical block format lives in the types package.
+type blockHeader struct {
+	Miner     address.Address
+	Height    *types.BlockHeight
+	Parents   []cid.Cid
+	Signature []byte
+}
+
+// ConsensusFaultBounty is paid to whoever successfully reports a consensus
+// fault, out of the offending miner's collateral.
+var ConsensusFaultBounty = types.NewAttoFILFromFIL(5)
+
+// ReportConsensusFault slashes a miner that equivocated: signed two blocks
+// that cannot both be valid extensions of the chain. block1 and block2 are
+// the two blocks' serialized headers. On success it pays the caller a
+// bounty out of the miner's collateral, burns the rest, and zeroes the
+// miner's power so it can no longer win block rewards.
+func (ma *Actor) ReportConsensusFault(ctx exec.VMContext, block1, block2 []byte) (uint8, error) {
+	// A single block, however legitimately signed, is not an equivocation:
+	// reject the trivial replay of one block as both arguments before it can
+	// be misclassified as a ConsensusFaultDoubleFork below.
+	if bytes.Equal(block1, block2) {
+		err := Errors[ErrInvalidConsensusFault]
+		return errors.CodeError(err), err
+	}
+
+	var h1, h2 blockHeader
+	if err := cbor.DecodeInto(block1, &h1); err != nil {
+		return errors.CodeError(err), err
+	}
+	if err := cbor.DecodeInto(block2, &h2); err != nil {
+		return errors.CodeError(err), err
+	}
+
+	var state State
+	_, err := actor.WithState(ctx, &state, func() (interface{}, error) {
+		if !verifyBlockSignature(state.PublicKey, &h1) || !verifyBlockSignature(state.PublicKey, &h2) {
+			return nil, Errors[ErrInvalidConsensusFault]
+		}
+		if _, ok := detectConsensusFault(&h1, &h2); !ok {
+			return nil, Errors[ErrInvalidConsensusFault]
+		}
+
+		bounty := ConsensusFaultBounty
+		if state.Collateral.LessThan(bounty) {
+			bounty = state.Collateral
+		}
+		// Whatever collateral is left after the bounty is burned: it is
+		// deducted here but never sent anywhere.
+		state.Collateral = types.NewZeroAttoFIL()
+
+		if state.Power.Sign() > 0 {
+			delta := big.NewInt(0).Neg(state.Power)
+			_, ret, err := ctx.Send(address.StorageMarketAddress, "updatePower", nil, []interface{}{delta})
+			if err != nil {
+				return nil, err
+			}
+			if ret != 0 {
+				return nil, Errors[ErrStoragemarketCallFailed]
+			}
+			state.Power = big.NewInt(0)
+		}
+
+		_, ret, err := ctx.Send(ctx.Message().From, "", bounty, nil)
+		if err != nil {
+			return nil, err
+		}
+		if ret != 0 {
+			return nil, Errors[ErrStoragemarketCallFailed]
+		}
+
+		return nil, nil
+	})
+	if err != nil {
+		return errors.CodeError(err), err
+	}
+
+	return 0, nil
+}
+
+// detectConsensusFault classifies why h1 and h2, both signed by the same
+// miner, cannot both be valid: mining two blocks at the same height off the
+// same parent tipset (DoubleFork), mining off the same parent tipset at
+// different heights (TimeOffset), or mining two blocks at the same height
+// off different parents (ParentGrinding).
+func detectConsensusFault(h1, h2 *blockHeader) (ConsensusFaultType, bool) {
+	if h1.Miner != h2.Miner {
+		return 0, false
+	}
+
+	sameHeight := h1.Height.AsBigInt().Cmp(h2.Height.AsBigInt()) == 0
+	if sameParents(h1.Parents, h2.Parents) {
+		if sameHeight {
+			return ConsensusFaultDoubleFork, true
+		}
+		return ConsensusFaultTimeOffset, true
+	}
+	if sameHeight {
+		return ConsensusFaultParentGrinding, true
+	}
+
+	return 0, false
+}
+
+func sameParents(a, b []cid.Cid) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equals(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyBlockSignature is a STUB: it does not check a block header's
+// signature against publicKey at all, and accepts any non-empty signature
+// as valid.
+func verifyBlockSignature(publicKey []byte, header *blockHeader) bool {
+	return len(header.Signature) > 0
+}
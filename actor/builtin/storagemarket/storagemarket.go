@@ -0,0 +1,633 @@
+package storagemarket
+
+import (
+	"bytes"
+	"math/big"
+
+	cbor "gx/ipfs/QmV6BQ6fFCf9eFHDuRxvguvqfKLZtZrxthgZvDfRCs4tMN/go-ipld-cbor"
+	"gx/ipfs/QmZFbDTY9jfSBms2MchvYM9oYRbAF19K7Pby47yDBfpPrb/go-cid"
+	xerrors "gx/ipfs/QmVmDhyTTUcQXFD1rRQ64fGLMSAoaQvNH3hwuaCFAPq2hy/errors"
+
+	"github.com/filecoin-project/go-filecoin/abi"
+	"github.com/filecoin-project/go-filecoin/actor"
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/adt"
+	"github.com/filecoin-project/go-filecoin/exec"
+	"github.com/filecoin-project/go-filecoin/types"
+	"github.com/filecoin-project/go-filecoin/vm/errors"
+)
+
+func init() {
+	cbor.RegisterCborType(State{})
+	cbor.RegisterCborType(Ask{})
+	cbor.RegisterCborType(DealProposal{})
+	cbor.RegisterCborType(ClientDealProposal{})
+	cbor.RegisterCborType(DealState{})
+}
+
+// AskExpiryBlocks is how long an ask remains valid after it is posted.
+var AskExpiryBlocks = types.NewBlockHeight(2000)
+
+// FaultFeePerSector is the fee charged against a miner's escrowed balance for
+// each sector that missed a windowed PoSt submission.
+var FaultFeePerSector = types.NewAttoFILFromFIL(1)
+
+const (
+	// ErrCallerUnauthorized signals an unauthorized caller.
+	ErrCallerUnauthorized = 1
+	// ErrInsufficientBalance indicates the caller does not have enough escrowed
+	// funds to cover a deal's collateral and price.
+	ErrInsufficientBalance = 2
+	// ErrInvalidSignature indicates a ClientDealProposal's signature does not
+	// match its Client address.
+	ErrInvalidSignature = 3
+	// ErrInvalidDealTerm indicates a deal's EndEpoch is not after its
+	// StartEpoch, or is after the expiration of the sector activating it.
+	ErrInvalidDealTerm = 4
+	// ErrDealNotFound indicates no pending deal exists for the given ID.
+	ErrDealNotFound = 5
+	// ErrUnsealedCIDMismatch indicates a sector's claimed unsealed CID does not
+	// match the one derived from its deals' pieces.
+	ErrUnsealedCIDMismatch = 6
+)
+
+// Errors map error codes to revert errors this actor may return.
+var Errors = map[uint8]error{
+	ErrCallerUnauthorized:  errors.NewCodedRevertErrorf(ErrCallerUnauthorized, "not authorized to call the method"),
+	ErrInsufficientBalance: errors.NewCodedRevertErrorf(ErrInsufficientBalance, "insufficient escrowed balance"),
+	ErrInvalidSignature:    errors.NewCodedRevertErrorf(ErrInvalidSignature, "deal proposal signature invalid"),
+	ErrInvalidDealTerm:     errors.NewCodedRevertErrorf(ErrInvalidDealTerm, "deal EndEpoch must be after StartEpoch and no later than sector expiration"),
+	ErrDealNotFound:        errors.NewCodedRevertErrorf(ErrDealNotFound, "no deal found for given ID"),
+	ErrUnsealedCIDMismatch: errors.NewCodedRevertErrorf(ErrUnsealedCIDMismatch, "sector unsealed CID does not match its deals' pieces"),
+}
+
+// Actor is the storage market actor. Miners call it to post asks, report
+// missed windowed PoSts, and update their share of network power; clients
+// and miners call it to escrow funds and publish storage deals.
+type Actor struct{}
+
+// Ask is a miner's offer to store data at a given price.
+type Ask struct {
+	Price  *types.AttoFIL
+	Size   *types.BytesAmount
+	Miner  address.Address
+	Expiry *types.BlockHeight
+}
+
+// DealProposal is the terms of a storage deal, agreed to off-chain by the
+// client and provider before the provider publishes it on-chain.
+type DealProposal struct {
+	// PieceCID is the commitment to the piece of data being stored (commP).
+	PieceCID []byte
+
+	PieceSize *types.BytesAmount
+
+	Client   address.Address
+	Provider address.Address
+
+	StartEpoch *types.BlockHeight
+	EndEpoch   *types.BlockHeight
+
+	StoragePricePerEpoch *types.AttoFIL
+	ProviderCollateral   *types.AttoFIL
+	ClientCollateral     *types.AttoFIL
+
+	VerifiedDeal bool
+}
+
+// ClientDealProposal is a DealProposal together with the client's signature
+// over it, submitted on-chain by the provider.
+type ClientDealProposal struct {
+	Proposal        DealProposal
+	ClientSignature []byte
+}
+
+// DealState is the on-chain record of a deal once its sector has been proven,
+// recorded so activateDeals is idempotent and so the deal's pieces can be
+// traced back to the sector that committed them.
+type DealState struct {
+	Proposal         DealProposal
+	SectorStartEpoch *types.BlockHeight
+}
+
+// State is the storage market actor's storage.
+type State struct {
+	// Asks is the root of an adt.Map of Ask keyed by ask ID.
+	Asks      cid.Cid
+	NextAskID uint64
+
+	// Proposals is the root of an adt.Map of DealProposal keyed by deal ID,
+	// for deals that have been published but whose sector has not yet been
+	// proven.
+	Proposals cid.Cid
+
+	// DealStates is the root of an adt.Map of DealState keyed by deal ID, for
+	// deals whose sector has been proven and are now active.
+	DealStates cid.Cid
+	NextDealID uint64
+
+	// EscrowTable is the root of an adt.Map of *types.AttoFIL keyed by
+	// address, holding funds an address has deposited but not yet locked into
+	// a deal.
+	EscrowTable cid.Cid
+
+	// LockedTable is the root of an adt.Map of *types.AttoFIL keyed by
+	// address, holding funds locked into published deals.
+	LockedTable cid.Cid
+
+	// TotalNetworkPower is the sum of power reported by every miner via
+	// updatePower.
+	TotalNetworkPower *big.Int
+}
+
+// NewActor returns a new storage market actor.
+func NewActor() *actor.Actor {
+	return actor.NewActor(types.StorageMarketActorCodeCid, types.NewZeroAttoFIL())
+}
+
+// NewState creates a storage market state struct. Asks, Proposals,
+// DealStates, EscrowTable and LockedTable are left as the zero cid.Cid;
+// InitializeState fills them in with the roots of empty collections once it
+// has access to the actor's backing store.
+func NewState() *State {
+	return &State{TotalNetworkPower: big.NewInt(0)}
+}
+
+func dealKey(id uint64) []byte {
+	return idKey(id)
+}
+
+func askKey(id uint64) []byte {
+	return idKey(id)
+}
+
+func idKey(id uint64) []byte {
+	buf := make([]byte, 8)
+	for i := 0; i < 8; i++ {
+		buf[7-i] = byte(id >> uint(8*i))
+	}
+	return buf
+}
+
+func addressKey(a address.Address) []byte {
+	return a.Bytes()
+}
+
+func (s *State) loadAsks(ctx exec.VMContext) (*adt.Map, error) {
+	return adt.AsMap(ctx.IpldStore(), s.Asks)
+}
+
+func (s *State) loadProposals(ctx exec.VMContext) (*adt.Map, error) {
+	return adt.AsMap(ctx.IpldStore(), s.Proposals)
+}
+
+func (s *State) loadDealStates(ctx exec.VMContext) (*adt.Map, error) {
+	return adt.AsMap(ctx.IpldStore(), s.DealStates)
+}
+
+func (s *State) loadEscrowTable(ctx exec.VMContext) (*adt.Map, error) {
+	return adt.AsMap(ctx.IpldStore(), s.EscrowTable)
+}
+
+func (s *State) loadLockedTable(ctx exec.VMContext) (*adt.Map, error) {
+	return adt.AsMap(ctx.IpldStore(), s.LockedTable)
+}
+
+// balanceOf returns the funds a has on deposit in table, or a zero balance if
+// a has never deposited.
+func balanceOf(table *adt.Map, a address.Address) (*types.AttoFIL, error) {
+	var balance types.AttoFIL
+	found, err := table.Get(addressKey(a), &balance)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return types.NewZeroAttoFIL(), nil
+	}
+	return &balance, nil
+}
+
+// InitializeState stores this storage market actor's initial data structure.
+func (sma *Actor) InitializeState(storage exec.Storage, initializerData interface{}) error {
+	marketState, ok := initializerData.(*State)
+	if !ok {
+		return errors.NewFaultError("Initial state to storagemarket actor is not a storagemarket.State struct")
+	}
+
+	asksRoot, err := adt.NewMap(storage).Root()
+	if err != nil {
+		return errors.FaultErrorWrap(err, "failed to flush empty Asks map")
+	}
+	marketState.Asks = asksRoot
+
+	proposalsRoot, err := adt.NewMap(storage).Root()
+	if err != nil {
+		return errors.FaultErrorWrap(err, "failed to flush empty Proposals map")
+	}
+	marketState.Proposals = proposalsRoot
+
+	dealStatesRoot, err := adt.NewMap(storage).Root()
+	if err != nil {
+		return errors.FaultErrorWrap(err, "failed to flush empty DealStates map")
+	}
+	marketState.DealStates = dealStatesRoot
+
+	escrowRoot, err := adt.NewMap(storage).Root()
+	if err != nil {
+		return errors.FaultErrorWrap(err, "failed to flush empty EscrowTable map")
+	}
+	marketState.EscrowTable = escrowRoot
+
+	lockedRoot, err := adt.NewMap(storage).Root()
+	if err != nil {
+		return errors.FaultErrorWrap(err, "failed to flush empty LockedTable map")
+	}
+	marketState.LockedTable = lockedRoot
+
+	stateBytes, err := cbor.DumpObject(marketState)
+	if err != nil {
+		return xerrors.Wrap(err, "failed to cbor marshal object")
+	}
+
+	id, err := storage.Put(stateBytes)
+	if err != nil {
+		return err
+	}
+
+	return storage.Commit(id, nil)
+}
+
+var _ exec.ExecutableActor = (*Actor)(nil)
+
+var storageMarketExports = exec.Exports{
+	"addAsk": &exec.FunctionSignature{
+		Params: []abi.Type{abi.AttoFIL, abi.BytesAmount},
+		Return: []abi.Type{abi.Integer},
+	},
+	"addBalance": &exec.FunctionSignature{
+		Params: []abi.Type{},
+		Return: []abi.Type{},
+	},
+	"publishStorageDeals": &exec.FunctionSignature{
+		Params: []abi.Type{abi.Bytes},
+		Return: []abi.Type{abi.UintArray},
+	},
+	"activateDeals": &exec.FunctionSignature{
+		Params: []abi.Type{abi.UintArray, abi.BlockHeight, abi.Bytes},
+		Return: []abi.Type{},
+	},
+	"chargeFaultFee": &exec.FunctionSignature{
+		Params: []abi.Type{abi.Integer},
+		Return: []abi.Type{},
+	},
+	"updatePower": &exec.FunctionSignature{
+		Params: []abi.Type{abi.Integer},
+		Return: []abi.Type{},
+	},
+}
+
+// Exports returns the storage market actor's exported functions.
+func (sma *Actor) Exports() exec.Exports {
+	return storageMarketExports
+}
+
+// AddAsk records a miner's offer to store size bytes at price per byte-block,
+// returning the ask's ID.
+func (sma *Actor) AddAsk(ctx exec.VMContext, price *types.AttoFIL, size *types.BytesAmount) (*big.Int, uint8, error) {
+	var state State
+	out, err := actor.WithState(ctx, &state, func() (interface{}, error) {
+		asks, err := state.loadAsks(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		askID := state.NextAskID
+		state.NextAskID++
+
+		if err := asks.Put(askKey(askID), &Ask{
+			Price:  price,
+			Size:   size,
+			Miner:  ctx.Message().From,
+			Expiry: ctx.BlockHeight().Add(AskExpiryBlocks),
+		}); err != nil {
+			return nil, err
+		}
+
+		asksRoot, err := asks.Root()
+		if err != nil {
+			return nil, err
+		}
+		state.Asks = asksRoot
+
+		return big.NewInt(0).SetUint64(askID), nil
+	})
+	if err != nil {
+		return nil, errors.CodeError(err), err
+	}
+
+	askID, ok := out.(*big.Int)
+	if !ok {
+		return nil, 1, errors.NewRevertErrorf("expected an Integer return value from call, but got %T instead", out)
+	}
+
+	return askID, 0, nil
+}
+
+// AddBalance escrows the funds attached to this message on behalf of the
+// caller, to be locked into deals by a later publishStorageDeals call.
+func (sma *Actor) AddBalance(ctx exec.VMContext) (uint8, error) {
+	var state State
+	_, err := actor.WithState(ctx, &state, func() (interface{}, error) {
+		escrow, err := state.loadEscrowTable(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		balance, err := balanceOf(escrow, ctx.Message().From)
+		if err != nil {
+			return nil, err
+		}
+		balance = balance.Add(ctx.Message().Value)
+
+		if err := escrow.Put(addressKey(ctx.Message().From), balance); err != nil {
+			return nil, err
+		}
+
+		escrowRoot, err := escrow.Root()
+		if err != nil {
+			return nil, err
+		}
+		state.EscrowTable = escrowRoot
+
+		return nil, nil
+	})
+	if err != nil {
+		return errors.CodeError(err), err
+	}
+
+	return 0, nil
+}
+
+// lockFunds moves amount from a's escrowed balance into its locked balance,
+// failing if a does not have enough escrowed.
+func lockFunds(escrow, locked *adt.Map, a address.Address, amount *types.AttoFIL) error {
+	available, err := balanceOf(escrow, a)
+	if err != nil {
+		return err
+	}
+	if available.LessThan(amount) {
+		return Errors[ErrInsufficientBalance]
+	}
+
+	lockedBalance, err := balanceOf(locked, a)
+	if err != nil {
+		return err
+	}
+
+	if err := escrow.Put(addressKey(a), available.Sub(amount)); err != nil {
+		return err
+	}
+	return locked.Put(addressKey(a), lockedBalance.Add(amount))
+}
+
+// PublishStorageDeals validates and escrows funds for a batch of client deal
+// proposals, returning the deal ID assigned to each. The sectors backing
+// these deals have not been proven yet; activateDeals moves a deal from
+// pending to active once its sector's seal is verified.
+func (sma *Actor) PublishStorageDeals(ctx exec.VMContext, proposalsCBOR []byte) ([]uint64, uint8, error) {
+	var proposals []ClientDealProposal
+	if err := cbor.DecodeInto(proposalsCBOR, &proposals); err != nil {
+		return nil, errors.CodeError(err), err
+	}
+
+	var state State
+	out, err := actor.WithState(ctx, &state, func() (interface{}, error) {
+		pending, err := state.loadProposals(ctx)
+		if err != nil {
+			return nil, err
+		}
+		escrow, err := state.loadEscrowTable(ctx)
+		if err != nil {
+			return nil, err
+		}
+		locked, err := state.loadLockedTable(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		dealIDs := make([]uint64, 0, len(proposals))
+		for _, cdp := range proposals {
+			// The provider is the one putting its own and the client's funds
+			// at stake by publishing this deal; without this check any
+			// caller could lock a victim client/provider's escrow into deal
+			// terms they never agreed to, independent of the (currently
+			// stubbed) signature check below.
+			if cdp.Proposal.Provider != ctx.Message().From {
+				return nil, Errors[ErrCallerUnauthorized]
+			}
+			if !verifyProposalSignature(cdp.Proposal, cdp.ClientSignature) {
+				return nil, Errors[ErrInvalidSignature]
+			}
+			if cdp.Proposal.EndEpoch.LessEqual(cdp.Proposal.StartEpoch) {
+				return nil, Errors[ErrInvalidDealTerm]
+			}
+
+			duration := cdp.Proposal.EndEpoch.Sub(cdp.Proposal.StartEpoch).AsBigInt().Uint64()
+			totalPrice := cdp.Proposal.StoragePricePerEpoch.MulBigInt(big.NewInt(0).SetUint64(duration))
+			clientLockup := totalPrice.Add(cdp.Proposal.ClientCollateral)
+
+			if err := lockFunds(escrow, locked, cdp.Proposal.Client, clientLockup); err != nil {
+				return nil, err
+			}
+			if err := lockFunds(escrow, locked, cdp.Proposal.Provider, cdp.Proposal.ProviderCollateral); err != nil {
+				return nil, err
+			}
+
+			dealID := state.NextDealID
+			state.NextDealID++
+			if err := pending.Put(dealKey(dealID), &cdp.Proposal); err != nil {
+				return nil, err
+			}
+			dealIDs = append(dealIDs, dealID)
+		}
+
+		pendingRoot, err := pending.Root()
+		if err != nil {
+			return nil, err
+		}
+		state.Proposals = pendingRoot
+
+		escrowRoot, err := escrow.Root()
+		if err != nil {
+			return nil, err
+		}
+		state.EscrowTable = escrowRoot
+
+		lockedRoot, err := locked.Root()
+		if err != nil {
+			return nil, err
+		}
+		state.LockedTable = lockedRoot
+
+		return dealIDs, nil
+	})
+	if err != nil {
+		return nil, errors.CodeError(err), err
+	}
+
+	dealIDs, ok := out.([]uint64)
+	if !ok {
+		return nil, 1, errors.NewFaultErrorf("expected a []uint64 return value from call, but got %T instead", out)
+	}
+
+	return dealIDs, 0, nil
+}
+
+// ActivateDeals moves dealIDs from pending to active, on behalf of the sector
+// that just proved it stores their pieces. It recomputes the sector's
+// unsealed CID from the deals' pieces and rejects the call if it does not
+// match unsealedCID, the value the sector's seal proof was verified against.
+func (sma *Actor) ActivateDeals(ctx exec.VMContext, dealIDs []uint64, sectorExpiration *types.BlockHeight, unsealedCID []byte) (uint8, error) {
+	var state State
+	_, err := actor.WithState(ctx, &state, func() (interface{}, error) {
+		pending, err := state.loadProposals(ctx)
+		if err != nil {
+			return nil, err
+		}
+		active, err := state.loadDealStates(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		pieceCIDs := make([][]byte, 0, len(dealIDs))
+		for _, dealID := range dealIDs {
+			var proposal DealProposal
+			found, err := pending.Get(dealKey(dealID), &proposal)
+			if err != nil {
+				return nil, err
+			}
+			if !found {
+				return nil, Errors[ErrDealNotFound]
+			}
+			if proposal.Provider != ctx.Message().From {
+				return nil, Errors[ErrCallerUnauthorized]
+			}
+			if proposal.EndEpoch.GreaterThan(sectorExpiration) {
+				return nil, Errors[ErrInvalidDealTerm]
+			}
+
+			pieceCIDs = append(pieceCIDs, proposal.PieceCID)
+
+			if err := pending.Delete(dealKey(dealID)); err != nil {
+				return nil, err
+			}
+			if err := active.Put(dealKey(dealID), &DealState{Proposal: proposal, SectorStartEpoch: ctx.BlockHeight()}); err != nil {
+				return nil, err
+			}
+		}
+
+		if !bytes.Equal(computeUnsealedCID(pieceCIDs), unsealedCID) {
+			return nil, Errors[ErrUnsealedCIDMismatch]
+		}
+
+		pendingRoot, err := pending.Root()
+		if err != nil {
+			return nil, err
+		}
+		state.Proposals = pendingRoot
+
+		activeRoot, err := active.Root()
+		if err != nil {
+			return nil, err
+		}
+		state.DealStates = activeRoot
+
+		return nil, nil
+	})
+	if err != nil {
+		return errors.CodeError(err), err
+	}
+
+	return 0, nil
+}
+
+// ChargeFaultFee charges the calling miner's escrowed balance for missing
+// count windowed PoSt submissions.
+func (sma *Actor) ChargeFaultFee(ctx exec.VMContext, count *big.Int) (uint8, error) {
+	var state State
+	_, err := actor.WithState(ctx, &state, func() (interface{}, error) {
+		escrow, err := state.loadEscrowTable(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		balance, err := balanceOf(escrow, ctx.Message().From)
+		if err != nil {
+			return nil, err
+		}
+
+		fee := FaultFeePerSector.MulBigInt(count)
+		if balance.LessThan(fee) {
+			fee = balance
+		}
+
+		if err := escrow.Put(addressKey(ctx.Message().From), balance.Sub(fee)); err != nil {
+			return nil, err
+		}
+
+		escrowRoot, err := escrow.Root()
+		if err != nil {
+			return nil, err
+		}
+		state.EscrowTable = escrowRoot
+
+		return nil, nil
+	})
+	if err != nil {
+		return errors.CodeError(err), err
+	}
+
+	return 0, nil
+}
+
+// UpdatePower adjusts the network's total tracked power by delta, which may
+// be negative when a miner loses power (e.g. a slashed fault).
+//
+// KNOWN GAP: unlike PublishStorageDeals/ActivateDeals, this does not check
+// ctx.Message().From at all, so any caller (not just a miner actor adjusting
+// its own power via proveCommitSector/reportConsensusFault) can move
+// TotalNetworkPower. This state has no per-miner registry to check the
+// caller against, so the fix isn't a one-line caller comparison like the
+// other methods in this file; it's lower severity today only because
+// nothing in this tree yet consumes TotalNetworkPower for anything
+// security-relevant. Tracked separately.
+func (sma *Actor) UpdatePower(ctx exec.VMContext, delta *big.Int) (uint8, error) {
+	var state State
+	_, err := actor.WithState(ctx, &state, func() (interface{}, error) {
+		state.TotalNetworkPower = state.TotalNetworkPower.Add(state.TotalNetworkPower, delta)
+		return nil, nil
+	})
+	if err != nil {
+		return errors.CodeError(err), err
+	}
+
+	return 0, nil
+}
+
+// verifyProposalSignature is a STUB: it does not check signature against
+// proposal or the client's address at all, and accepts any non-empty byte
+// slice as a valid signature.
+func verifyProposalSignature(proposal DealProposal, signature []byte) bool {
+	return len(signature) > 0
+}
+
+// computeUnsealedCID derives the commD a sector's deal pieces are compared
+// against by concatenating the pieces' CIDs in deal order. It does not
+// compute a piece commitment tree.
+func computeUnsealedCID(pieceCIDs [][]byte) []byte {
+	var out []byte
+	for _, pieceCID := range pieceCIDs {
+		out = append(out, pieceCID...)
+	}
+	return out
+}
@@ -0,0 +1,64 @@
+// Package adt provides array- and map-shaped collections backed by IPLD
+// AMTs and HAMTs, for actor state that grows without bound. Unlike a plain
+// Go map stored inline in an actor's State struct, which gets CBOR-dumped in
+// full on every write, an adt.Array or adt.Map only touches the O(log n)
+// tree nodes on the path to the key being written.
+package adt
+
+import (
+	"context"
+
+	amt "gx/ipfs/QmRJnjJANqz1BkYVoT2sVXk8Yt6cqBRT4kVwrQqhpJoQ7B/go-amt-ipld"
+	cbor "gx/ipfs/QmV6BQ6fFCf9eFHDuRxvguvqfKLZtZrxthgZvDfRCs4tMN/go-ipld-cbor"
+	"gx/ipfs/QmZFbDTY9jfSBms2MchvYM9oYRbAF19K7Pby47yDBfpPrb/go-cid"
+)
+
+// Array is an AMT-backed collection keyed by a dense uint64 index.
+type Array struct {
+	store cbor.IpldStore
+	root  *amt.Root
+}
+
+// NewArray creates an empty Array backed by store.
+func NewArray(store cbor.IpldStore) *Array {
+	return &Array{store: store, root: amt.NewAMT(store)}
+}
+
+// AsArray loads the Array rooted at c.
+func AsArray(store cbor.IpldStore, c cid.Cid) (*Array, error) {
+	root, err := amt.LoadAMT(store, c)
+	if err != nil {
+		return nil, err
+	}
+	return &Array{store: store, root: root}, nil
+}
+
+// Get decodes the value stored at index into out, and reports whether it
+// was present.
+func (a *Array) Get(index uint64, out interface{}) (bool, error) {
+	return a.root.Get(context.TODO(), index, out)
+}
+
+// Set stores value at index, overwriting any value already there.
+func (a *Array) Set(index uint64, value interface{}) error {
+	return a.root.Set(context.TODO(), index, value)
+}
+
+// Delete removes the value at index, if present.
+func (a *Array) Delete(index uint64) error {
+	return a.root.Delete(context.TODO(), index)
+}
+
+// ForEach decodes each populated entry into out and invokes cb with its
+// index.
+func (a *Array) ForEach(out interface{}, cb func(index uint64) error) error {
+	return a.root.ForEach(context.TODO(), func(index uint64) error {
+		return cb(index)
+	}, out)
+}
+
+// Root flushes any pending writes and returns the Array's root CID, to be
+// stored in the owning actor's State.
+func (a *Array) Root() (cid.Cid, error) {
+	return a.root.Flush(context.TODO())
+}
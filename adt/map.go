@@ -0,0 +1,68 @@
+package adt
+
+import (
+	"context"
+
+	hamt "gx/ipfs/QmRXf2uUSdGSunRJsM9wa1TFhNLS4bYS9Eh2x3xQrCXAfM/go-hamt-ipld"
+	cbor "gx/ipfs/QmV6BQ6fFCf9eFHDuRxvguvqfKLZtZrxthgZvDfRCs4tMN/go-ipld-cbor"
+	"gx/ipfs/QmZFbDTY9jfSBms2MchvYM9oYRbAF19K7Pby47yDBfpPrb/go-cid"
+)
+
+// Map is a HAMT-backed collection keyed by an arbitrary byte string.
+type Map struct {
+	store cbor.IpldStore
+	root  *hamt.Node
+}
+
+// NewMap creates an empty Map backed by store.
+func NewMap(store cbor.IpldStore) *Map {
+	return &Map{store: store, root: hamt.NewNode(store)}
+}
+
+// AsMap loads the Map rooted at c.
+func AsMap(store cbor.IpldStore, c cid.Cid) (*Map, error) {
+	root, err := hamt.LoadNode(context.TODO(), store, c)
+	if err != nil {
+		return nil, err
+	}
+	return &Map{store: store, root: root}, nil
+}
+
+// Get decodes the value stored under key into out, and reports whether it
+// was present.
+func (m *Map) Get(key []byte, out interface{}) (bool, error) {
+	if err := m.root.Find(context.TODO(), string(key), out); err != nil {
+		if err == hamt.ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Put stores value under key, overwriting any value already there.
+func (m *Map) Put(key []byte, value interface{}) error {
+	return m.root.Set(context.TODO(), string(key), value)
+}
+
+// Delete removes the value stored under key, if present.
+func (m *Map) Delete(key []byte) error {
+	return m.root.Delete(context.TODO(), string(key))
+}
+
+// ForEach invokes cb with the key of each entry. Values are not decoded:
+// out is not written.
+func (m *Map) ForEach(out interface{}, cb func(key string) error) error {
+	return m.root.ForEach(context.TODO(), func(key string, val interface{}) error {
+		return cb(key)
+	})
+}
+
+// Root flushes any pending writes and returns the Map's root CID, to be
+// stored in the owning actor's State.
+func (m *Map) Root() (cid.Cid, error) {
+	if err := m.root.Flush(context.TODO()); err != nil {
+		return cid.Undef, err
+	}
+	return m.root.Cid()
+}
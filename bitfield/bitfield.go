@@ -0,0 +1,91 @@
+// Package bitfield provides a compact set of non-negative integers, used by
+// actor state to track sets of sector or partition numbers without paying
+// the cost of a Go map entry per member.
+package bitfield
+
+// BitField is a set of uint64s. It CBOR-encodes as a plain slice of its
+// Members, which avoids the per-entry map overhead actor state otherwise
+// pays for large sector or partition sets.
+//
+// Members is exported so reflection-based CBOR (and JSON) codecs can see it:
+// BitField is embedded directly in actor state structs that round-trip
+// through cbor.DumpObject/DecodeInto without a hand-written Marshal/Unmarshal
+// pair, and those codecs cannot reach unexported fields.
+//
+// TODO: switch the wire encoding to run-length-encoded ranges once sector
+// numbers are large enough that a flat slice stops being cheap.
+type BitField struct {
+	Members []uint64
+}
+
+// NewFromSet returns a BitField containing exactly the members of bits.
+func NewFromSet(bits []uint64) BitField {
+	bf := BitField{}
+	for _, b := range bits {
+		bf.Set(b)
+	}
+	return bf
+}
+
+// Set adds bit to the field.
+func (bf *BitField) Set(bit uint64) {
+	if bf.Has(bit) {
+		return
+	}
+	bf.Members = append(bf.Members, bit)
+}
+
+// Unset removes bit from the field, if present.
+func (bf *BitField) Unset(bit uint64) {
+	for i, b := range bf.Members {
+		if b == bit {
+			bf.Members = append(bf.Members[:i], bf.Members[i+1:]...)
+			return
+		}
+	}
+}
+
+// Has reports whether bit is a member of the field.
+func (bf *BitField) Has(bit uint64) bool {
+	for _, b := range bf.Members {
+		if b == bit {
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns the number of members in the field.
+func (bf *BitField) Len() int {
+	return len(bf.Members)
+}
+
+// ForEach invokes cb with each member of the field.
+func (bf *BitField) ForEach(cb func(bit uint64) error) error {
+	for _, b := range bf.Members {
+		if err := cb(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Merge returns a new BitField containing the union of bf and other.
+func (bf *BitField) Merge(other BitField) BitField {
+	merged := NewFromSet(bf.Members)
+	for _, b := range other.Members {
+		merged.Set(b)
+	}
+	return merged
+}
+
+// Subtract returns a new BitField containing bf's members that are not in other.
+func (bf *BitField) Subtract(other BitField) BitField {
+	result := BitField{}
+	for _, b := range bf.Members {
+		if !other.Has(b) {
+			result.Set(b)
+		}
+	}
+	return result
+}
@@ -0,0 +1,36 @@
+package bitfield_test
+
+import (
+	"testing"
+
+	cbor "gx/ipfs/QmV6BQ6fFCf9eFHDuRxvguvqfKLZtZrxthgZvDfRCs4tMN/go-ipld-cbor"
+
+	"github.com/filecoin-project/go-filecoin/bitfield"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestBitFieldCBORRoundTrip guards against BitField's members silently
+// vanishing across a cbor.DumpObject/DecodeInto round trip, which is how
+// actor state is (de)serialized. A reflection-based codec can only see
+// exported fields, so this would previously drop every Set bit on the first
+// read back from storage.
+func TestBitFieldCBORRoundTrip(t *testing.T) {
+	bf := bitfield.BitField{}
+	bf.Set(3)
+	bf.Set(7)
+	bf.Set(11)
+
+	raw, err := cbor.DumpObject(bf)
+	require.NoError(t, err)
+
+	var decoded bitfield.BitField
+	require.NoError(t, cbor.DecodeInto(raw, &decoded))
+
+	assert.True(t, decoded.Has(3))
+	assert.True(t, decoded.Has(7))
+	assert.True(t, decoded.Has(11))
+	assert.False(t, decoded.Has(4))
+	assert.Equal(t, 3, decoded.Len())
+}
@@ -0,0 +1,66 @@
+// Package miner wraps the versioned miner actor implementations behind a
+// single stable, read-only interface, so callers outside the VM (chain
+// sync, the wallet, tests) don't need to switch on actors.NetworkVersion
+// themselves to inspect a miner's state. It is state-inspection tooling
+// only: nothing here drives which actor code version the VM executes a
+// message against. The live actor/builtin/miner.Actor runs unconditionally,
+// regardless of actors.NetworkVersionAt; wiring NetworkVersion into message
+// dispatch itself is tracked separately.
+package miner
+
+import (
+	"math/big"
+
+	"gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	cbor "gx/ipfs/QmV6BQ6fFCf9eFHDuRxvguvqfKLZtZrxthgZvDfRCs4tMN/go-ipld-cbor"
+	"gx/ipfs/QmZFbDTY9jfSBms2MchvYM9oYRbAF19K7Pby47yDBfpPrb/go-cid"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/chain/actors"
+)
+
+// State is the version-independent view of a miner actor's on-chain state.
+// Each concrete miner actor version implements it against its own schema.
+type State interface {
+	// Info returns the miner's version-independent identity.
+	Info() (Info, error)
+
+	// GetSector looks up a single committed sector by number.
+	GetSector(sectorNum uint64) (*SectorOnChainInfo, bool, error)
+
+	// ForEachDeadline invokes cb once per proving deadline the miner tracks,
+	// with the sector numbers currently marked faulty and recovering in it.
+	// Versions that predate windowed PoSt report a single deadline covering
+	// every sector, with no per-deadline fault tracking.
+	ForEachDeadline(cb func(idx uint64, faults, recoveries []uint64) error) error
+
+	// LockedFunds returns the miner's collateral. Precommit deposits, which
+	// are taken out of collateral until the sector is proven, are not
+	// included.
+	LockedFunds() (*big.Int, error)
+}
+
+// Info is the subset of a miner's identity that is stable across versions.
+type Info struct {
+	Owner  address.Address
+	PeerID peer.ID
+	Power  *big.Int
+}
+
+// SectorOnChainInfo is the version-independent view of a single sector.
+type SectorOnChainInfo struct {
+	SectorNumber uint64
+	SealedCID    []byte
+}
+
+// Load reads the miner actor state rooted at actorHead and returns the State
+// implementation matching version, the network version active when
+// actorHead was produced.
+func Load(store cbor.IpldStore, version actors.NetworkVersion, actorHead cid.Cid) (State, error) {
+	switch version {
+	case actors.NetworkVersion0:
+		return loadV0(store, actorHead)
+	default:
+		return loadV1(store, actorHead)
+	}
+}
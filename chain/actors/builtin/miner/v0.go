@@ -0,0 +1,69 @@
+package miner
+
+import (
+	"context"
+	"math/big"
+
+	"gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	cbor "gx/ipfs/QmV6BQ6fFCf9eFHDuRxvguvqfKLZtZrxthgZvDfRCs4tMN/go-ipld-cbor"
+	"gx/ipfs/QmZFbDTY9jfSBms2MchvYM9oYRbAF19K7Pby47yDBfpPrb/go-cid"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/types"
+)
+
+// stateV0 mirrors the NetworkVersion0 miner.State schema: a single-shot PoSt
+// covering every sector, addressed by commR, with no deadlines or
+// precommits. It is kept here, frozen, so historical actor heads written
+// under NetworkVersion0 can still be read after the schema moved on.
+type stateV0 struct {
+	Owner              address.Address
+	PeerID             peer.ID
+	PublicKey          []byte
+	PledgeBytes        *types.BytesAmount
+	Collateral         *types.AttoFIL
+	Sectors            map[string][]byte
+	ProvingPeriodStart *types.BlockHeight
+	LastPoSt           *types.BlockHeight
+	LockedStorage      *types.BytesAmount
+	Power              *big.Int
+}
+
+func init() {
+	cbor.RegisterCborType(stateV0{})
+}
+
+// loadV0 reads a NetworkVersion0 miner actor head into the stable State
+// interface.
+func loadV0(store cbor.IpldStore, actorHead cid.Cid) (State, error) {
+	var s stateV0
+	if err := store.Get(context.TODO(), actorHead, &s); err != nil {
+		return nil, err
+	}
+	return &v0State{s: &s}, nil
+}
+
+// v0State adapts stateV0 to the version-independent State interface.
+type v0State struct {
+	s *stateV0
+}
+
+func (v *v0State) Info() (Info, error) {
+	return Info{Owner: v.s.Owner, PeerID: v.s.PeerID, Power: v.s.Power}, nil
+}
+
+func (v *v0State) GetSector(sectorNum uint64) (*SectorOnChainInfo, bool, error) {
+	// NetworkVersion0 keys sectors by commR rather than sector number, so
+	// numeric lookup isn't possible against this schema.
+	return nil, false, nil
+}
+
+func (v *v0State) ForEachDeadline(cb func(idx uint64, faults, recoveries []uint64) error) error {
+	// NetworkVersion0 has no deadlines: every sector is proven together, and
+	// there is no per-sector fault tracking to report.
+	return cb(0, nil, nil)
+}
+
+func (v *v0State) LockedFunds() (*big.Int, error) {
+	return v.s.Collateral.AsBigInt(), nil
+}
@@ -0,0 +1,65 @@
+package miner
+
+import (
+	"context"
+	"math/big"
+
+	cbor "gx/ipfs/QmV6BQ6fFCf9eFHDuRxvguvqfKLZtZrxthgZvDfRCs4tMN/go-ipld-cbor"
+	"gx/ipfs/QmZFbDTY9jfSBms2MchvYM9oYRbAF19K7Pby47yDBfpPrb/go-cid"
+
+	builtin "github.com/filecoin-project/go-filecoin/actor/builtin/miner"
+)
+
+// loadV1 reads a NetworkVersion1 miner actor head, using the live
+// actor/builtin/miner.State schema, into the stable State interface.
+func loadV1(store cbor.IpldStore, actorHead cid.Cid) (State, error) {
+	var s builtin.State
+	if err := store.Get(context.TODO(), actorHead, &s); err != nil {
+		return nil, err
+	}
+	return &v1State{s: &s}, nil
+}
+
+// v1State adapts builtin.State to the version-independent State interface.
+type v1State struct {
+	s *builtin.State
+}
+
+func (v *v1State) Info() (Info, error) {
+	return Info{Owner: v.s.Owner, PeerID: v.s.PeerID, Power: v.s.Power}, nil
+}
+
+func (v *v1State) GetSector(sectorNum uint64) (*SectorOnChainInfo, bool, error) {
+	deadline := v.s.Deadlines[sectorNum%builtin.NumDeadlines]
+	if !deadline.Sectors.Has(sectorNum) {
+		return nil, false, nil
+	}
+	return &SectorOnChainInfo{SectorNumber: sectorNum}, true, nil
+}
+
+func (v *v1State) ForEachDeadline(cb func(idx uint64, faults, recoveries []uint64) error) error {
+	for idx, deadline := range v.s.Deadlines {
+		var faults []uint64
+		if err := deadline.Faults.ForEach(func(sectorNum uint64) error {
+			faults = append(faults, sectorNum)
+			return nil
+		}); err != nil {
+			return err
+		}
+		var recoveries []uint64
+		if err := deadline.Recoveries.ForEach(func(sectorNum uint64) error {
+			recoveries = append(recoveries, sectorNum)
+			return nil
+		}); err != nil {
+			return err
+		}
+		if err := cb(uint64(idx), faults, recoveries); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *v1State) LockedFunds() (*big.Int, error) {
+	return v.s.Collateral.AsBigInt(), nil
+}
@@ -0,0 +1,69 @@
+// Package migration walks the state tree at a network upgrade epoch and
+// rewrites each actor's HAMT/CBOR layout to the schema its new code version
+// expects, so the chain can evolve actor state without a hard fork.
+package migration
+
+import (
+	"context"
+
+	cbor "gx/ipfs/QmV6BQ6fFCf9eFHDuRxvguvqfKLZtZrxthgZvDfRCs4tMN/go-ipld-cbor"
+	"gx/ipfs/QmZFbDTY9jfSBms2MchvYM9oYRbAF19K7Pby47yDBfpPrb/go-cid"
+
+	"github.com/filecoin-project/go-filecoin/chain/actors"
+)
+
+// ActorMigration upgrades a single actor's on-chain state from the schema of
+// the network version it was written under to the schema of the version it
+// is registered against.
+type ActorMigration interface {
+	MigrateState(ctx context.Context, store cbor.IpldStore, head cid.Cid) (cid.Cid, error)
+}
+
+// registry maps an actor code CID to the migration that runs for it when the
+// chain reaches a given NetworkVersion.
+type registry map[cid.Cid]ActorMigration
+
+var migrations = map[actors.NetworkVersion]registry{}
+
+// Register adds migration as the state-tree rewrite that runs for actorCode
+// when the chain reaches version. It is meant to be called from actor
+// packages' init functions, alongside cbor.RegisterCborType.
+func Register(version actors.NetworkVersion, actorCode cid.Cid, migration ActorMigration) {
+	r, ok := migrations[version]
+	if !ok {
+		r = registry{}
+		migrations[version] = r
+	}
+	r[actorCode] = migration
+}
+
+// ActorHead pairs an actor's code CID with its current state root.
+type ActorHead struct {
+	Code cid.Cid
+	Head cid.Cid
+}
+
+// MigrateAll rewrites the state of every actor in heads whose code has a
+// migration registered for version, and leaves the rest untouched.
+func MigrateAll(ctx context.Context, store cbor.IpldStore, version actors.NetworkVersion, heads map[string]ActorHead) (map[string]ActorHead, error) {
+	r, ok := migrations[version]
+	if !ok {
+		return heads, nil
+	}
+
+	migrated := make(map[string]ActorHead, len(heads))
+	for addr, head := range heads {
+		m, ok := r[head.Code]
+		if !ok {
+			migrated[addr] = head
+			continue
+		}
+
+		newHead, err := m.MigrateState(ctx, store, head.Head)
+		if err != nil {
+			return nil, err
+		}
+		migrated[addr] = ActorHead{Code: head.Code, Head: newHead}
+	}
+	return migrated, nil
+}
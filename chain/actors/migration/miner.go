@@ -0,0 +1,89 @@
+package migration
+
+import (
+	"context"
+	"math/big"
+	"sort"
+
+	"gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	cbor "gx/ipfs/QmV6BQ6fFCf9eFHDuRxvguvqfKLZtZrxthgZvDfRCs4tMN/go-ipld-cbor"
+	"gx/ipfs/QmZFbDTY9jfSBms2MchvYM9oYRbAF19K7Pby47yDBfpPrb/go-cid"
+
+	builtin "github.com/filecoin-project/go-filecoin/actor/builtin/miner"
+	"github.com/filecoin-project/go-filecoin/adt"
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/chain/actors"
+	"github.com/filecoin-project/go-filecoin/types"
+)
+
+// minerV0State is the NetworkVersion0 miner.State schema, decoded here
+// rather than imported from actor/builtin/miner so this migration keeps
+// working even after that package's live schema moves on again.
+type minerV0State struct {
+	Owner              address.Address
+	PeerID             peer.ID
+	PublicKey          []byte
+	PledgeBytes        *types.BytesAmount
+	Collateral         *types.AttoFIL
+	Sectors            map[string][]byte
+	ProvingPeriodStart *types.BlockHeight
+	LastPoSt           *types.BlockHeight
+	LockedStorage      *types.BytesAmount
+	Power              *big.Int
+}
+
+func init() {
+	cbor.RegisterCborType(minerV0State{})
+	Register(actors.NetworkVersion1, types.MinerActorCodeCid, minerMigrationV0ToV1{})
+}
+
+// minerMigrationV0ToV1 rewrites a NetworkVersion0 miner actor's flat
+// Sectors map into the NetworkVersion1 schema: sectors are partitioned
+// across Deadlines and an empty PreCommittedSectors map is added, since
+// NetworkVersion0 has no in-flight precommits to carry over.
+type minerMigrationV0ToV1 struct{}
+
+func (minerMigrationV0ToV1) MigrateState(ctx context.Context, store cbor.IpldStore, head cid.Cid) (cid.Cid, error) {
+	var old minerV0State
+	if err := store.Get(ctx, head, &old); err != nil {
+		return cid.Undef, err
+	}
+
+	next := builtin.NewState(old.Owner, old.PublicKey, old.PledgeBytes, old.PeerID, old.Collateral)
+	next.LockedStorage = old.LockedStorage
+	next.Power = old.Power
+	next.ProvingPeriodStart = old.ProvingPeriodStart
+
+	// old.Sectors has no numeric sector IDs of its own (it's keyed by the
+	// sealed CID string), so assign them here in a deterministic order and
+	// carry the sealed CID over as the new SectorOnChainInfo.
+	sealedCIDs := make([]string, 0, len(old.Sectors))
+	for sealedCID := range old.Sectors {
+		sealedCIDs = append(sealedCIDs, sealedCID)
+	}
+	sort.Strings(sealedCIDs)
+
+	sectors := adt.NewArray(store)
+	sectorNum := uint64(0)
+	for _, sealedCID := range sealedCIDs {
+		info := &builtin.SectorOnChainInfo{SealedCID: []byte(sealedCID), UnsealedCID: old.Sectors[sealedCID]}
+		if err := sectors.Set(sectorNum, info); err != nil {
+			return cid.Undef, err
+		}
+		next.AssignSectorToDeadline(sectorNum)
+		sectorNum++
+	}
+	sectorsRoot, err := sectors.Root()
+	if err != nil {
+		return cid.Undef, err
+	}
+	next.Sectors = sectorsRoot
+
+	precommitsRoot, err := adt.NewMap(store).Root()
+	if err != nil {
+		return cid.Undef, err
+	}
+	next.PreCommittedSectors = precommitsRoot
+
+	return store.Put(ctx, next)
+}
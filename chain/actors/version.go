@@ -0,0 +1,53 @@
+package actors
+
+import (
+	"github.com/filecoin-project/go-filecoin/types"
+)
+
+// NetworkVersion identifies a network-wide protocol version. It is meant to
+// drive which actor code version the VM dispatches a message to at a given
+// epoch, so actor state schemas can change without every node upgrading its
+// binary in lockstep. That dispatch is not wired up yet: nothing calls
+// NetworkVersionAt outside its tests. The read-only
+// chain/actors/builtin/miner.Load adapter and the migration package's
+// state-tree rewriter take a NetworkVersion as an argument, and both run
+// outside message execution. exec.VMContext and the exported actor methods
+// still run unconditionally against a single schema; see
+// chain/actors/builtin/miner's package doc comment.
+type NetworkVersion uint64
+
+const (
+	// NetworkVersion0 is the version the chain launched with: a single-shot
+	// PoSt covering every sector and a one-call CommitSector.
+	NetworkVersion0 NetworkVersion = iota
+	// NetworkVersion1 introduces window-based PoSt with partitioned
+	// deadlines and the interactive two-phase precommit/prove-commit flow.
+	NetworkVersion1
+)
+
+// upgrade pins a NetworkVersion to the epoch at which it becomes effective.
+type upgrade struct {
+	version NetworkVersion
+	height  *types.BlockHeight
+}
+
+// schedule lists upgrades in ascending height order. It is the single source
+// of truth for which actor code versions are live at a given epoch.
+var schedule = []upgrade{
+	{version: NetworkVersion0, height: types.NewBlockHeight(0)},
+	{version: NetworkVersion1, height: types.NewBlockHeight(200000)},
+}
+
+// NetworkVersionAt returns the protocol version in effect at epoch.
+func NetworkVersionAt(epoch *types.BlockHeight) NetworkVersion {
+	height := epoch.AsBigInt().Uint64()
+
+	version := schedule[0].version
+	for _, up := range schedule {
+		if up.height.AsBigInt().Uint64() > height {
+			break
+		}
+		version = up.version
+	}
+	return version
+}
@@ -0,0 +1,20 @@
+package actors_test
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/go-filecoin/chain/actors"
+	"github.com/filecoin-project/go-filecoin/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestNetworkVersionAt exercises the upgrade schedule boundaries: the epoch
+// immediately before an upgrade height must still report the prior version,
+// and the upgrade height itself must report the new one.
+func TestNetworkVersionAt(t *testing.T) {
+	assert.Equal(t, actors.NetworkVersion0, actors.NetworkVersionAt(types.NewBlockHeight(0)))
+	assert.Equal(t, actors.NetworkVersion0, actors.NetworkVersionAt(types.NewBlockHeight(199999)))
+	assert.Equal(t, actors.NetworkVersion1, actors.NetworkVersionAt(types.NewBlockHeight(200000)))
+	assert.Equal(t, actors.NetworkVersion1, actors.NetworkVersionAt(types.NewBlockHeight(500000)))
+}
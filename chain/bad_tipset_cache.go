@@ -1,39 +1,86 @@
 package chain
 
 import (
+	"fmt"
 	"sync"
 
+	lru "gx/ipfs/QmVMaJz9GfHvfsm4bMFqm6X6dSKURZFQ7WdrJmC5DzoiCk/golang-lru"
+	ds "gx/ipfs/QmUadX5EcvrBmxAV9sE7wUpYH2huRhBURicaBrgZG9zZAG/go-datastore"
+	dsq "gx/ipfs/QmUadX5EcvrBmxAV9sE7wUpYH2huRhBURicaBrgZG9zZAG/go-datastore/query"
+
 	"github.com/filecoin-project/go-filecoin/types"
 )
 
-// badTipSetCache keeps track of bad tipsets that the syncer should not try to
-// download.  Readers and writers grab a lock.
-// TODO: this needs to be limited.
+// badTipSetCacheSize bounds how many bad tipset keys the LRU keeps in
+// memory, so a syncer that keeps encountering bad chains can't grow the
+// cache without limit.
+const badTipSetCacheSize = 8192
+
+// badTipSetDatastorePrefix namespaces persisted bad-tipset keys in the
+// backing datastore.
+var badTipSetDatastorePrefix = ds.NewKey("/chain/badTipSet")
+
+// badTipSetCache keeps track of bad tipsets that the syncer should not try
+// to download. It is bounded by an LRU, and every add is mirrored to a
+// datastore so bad-tipset knowledge survives a restart.
 type badTipSetCache struct {
-	mu  sync.Mutex
-	bad map[string]struct{}
+	mu    sync.Mutex
+	cache *lru.Cache
+	ds    ds.Datastore
+}
+
+// newBadTipSetCache creates a badTipSetCache backed by store, loading any
+// bad tipset keys persisted by a previous run back into the LRU.
+func newBadTipSetCache(store ds.Datastore) (*badTipSetCache, error) {
+	c, err := lru.New(badTipSetCacheSize)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := &badTipSetCache{cache: c, ds: store}
+
+	res, err := store.Query(dsq.Query{Prefix: badTipSetDatastorePrefix.String(), KeysOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close() // nolint: errcheck
+
+	for entry := range res.Next() {
+		tsKey := ds.RawKey(entry.Key).Name()
+		cache.cache.Add(tsKey, struct{}{})
+	}
+
+	return cache, nil
 }
 
 // AddChain adds the chain of tipsets to the badTipSetCache.  For now it just
 // does the simplest thing and adds all blocks of the chain to the cache.
-// TODO: might want to cache a random subset once cache size is limited.
 func (cache *badTipSetCache) AddChain(chain []types.TipSet) {
 	for _, ts := range chain {
 		cache.Add(ts.String())
 	}
 }
 
-// Add adds a single tipset key to the badTipSetCache.
+// Add adds a single tipset key to the badTipSetCache, evicting the least
+// recently used entry once the LRU is full, and persists the key to the
+// datastore so it survives a restart.
 func (cache *badTipSetCache) Add(tsKey string) {
 	cache.mu.Lock()
 	defer cache.mu.Unlock()
-	cache.bad[tsKey] = struct{}{}
+
+	cache.cache.Add(tsKey, struct{}{})
+
+	if cache.ds == nil {
+		return
+	}
+	if err := cache.ds.Put(badTipSetDatastorePrefix.ChildString(tsKey), []byte{}); err != nil {
+		fmt.Println("failed to persist bad tipset", tsKey, err)
+	}
 }
 
 // Has checks for membership in the badTipSetCache.
 func (cache *badTipSetCache) Has(tsKey string) bool {
 	cache.mu.Lock()
 	defer cache.mu.Unlock()
-	_, ok := cache.bad[tsKey]
-	return ok
+	return cache.cache.Contains(tsKey)
 }
@@ -90,8 +90,8 @@ func TestStorageProtocolBasic(t *testing.T) {
 		// }
 		if !foundSeal {
 			for i, msg := range blk.Messages {
-				if msg.Message.Method == "commitSector" {
-					assert.False(foundSeal, "multiple commitSector submissions must not happen")
+				if msg.Message.Method == "proveCommitSector" {
+					assert.False(foundSeal, "multiple proveCommitSector submissions must not happen")
 					assert.Equal(uint8(0), blk.MessageReceipts[i].ExitCode, "seal submission failed")
 					foundSeal = true
 					wg.Done()
@@ -100,7 +100,7 @@ func TestStorageProtocolBasic(t *testing.T) {
 		}
 		if !foundPoSt {
 			for i, msg := range blk.Messages {
-				if msg.Message.Method == "submitPoSt" {
+				if msg.Message.Method == "submitWindowedPoSt" {
 					assert.False(foundPoSt, "multiple post submissions must not happen")
 					assert.Equal(uint8(0), blk.MessageReceipts[i].ExitCode, "post submission failed")
 					foundPoSt = true
@@ -113,6 +113,12 @@ func TestStorageProtocolBasic(t *testing.T) {
 	ref, err := c.TryToStoreData(ctx, mineraddr, protonode.Cid(), 10, types.NewAttoFILFromFIL(60))
 	assert.NoError(err)
 
+	// TODO: this sleep-and-poll is still here, not because it was missed,
+	// but because synchronizing on the real "proposal accepted, now Staged"
+	// event requires the client/miner deal state machine described by this
+	// test (node/storage_protocol.go) to actually exist; it doesn't in this
+	// tree yet (see 73e52b6's commit message). Rewriting this into an
+	// event-driven wait is blocked on that file landing, not on this test.
 	time.Sleep(time.Millisecond * 100) // Bad whyrusleeping, bad!
 
 	resp, err := c.Query(ctx, ref)